@@ -0,0 +1,217 @@
+package coap
+
+import (
+	"net"
+	"sync"
+)
+
+// Continue is the 2.31 response code a blockwise transfer uses to
+// acknowledge a non-final Block1 fragment.
+const Continue = 95
+
+// defaultBlockSZX is used when a server has not configured its own
+// preferred block size via BlockwiseTransport.SZX.
+const defaultBlockSZX = 6 // 2^(6+4) = 1024 bytes
+
+// decodeBlockOption unpacks a Block1/Block2 option value, per RFC 7959
+// section 2.2: NUM occupies the bits above bit 3, M is bit 3, and SZX
+// is the low 3 bits. A nil value (option absent) decodes as block 0,
+// no more blocks, default size.
+func decodeBlockOption(v interface{}) (num uint32, more bool, szx uint8) {
+	if v == nil {
+		return 0, false, defaultBlockSZX
+	}
+	n := v.(uint32)
+	return n >> 4, n&0x8 != 0, uint8(n & 0x7)
+}
+
+func encodeBlockOption(num uint32, more bool, szx uint8) uint32 {
+	v := num<<4 | uint32(szx&0x7)
+	if more {
+		v |= 0x8
+	}
+	return v
+}
+
+// blockSize returns the number of payload bytes carried per block for
+// a given SZX, i.e. 2^(szx+4).
+func blockSize(szx uint8) int {
+	return 1 << (uint(szx) + 4)
+}
+
+type blockwiseKey struct {
+	addr  string
+	token string
+}
+
+// blockwiseRecv tracks a Block1 reassembly in progress, including the
+// next block number expected, so a retransmitted or out-of-order
+// fragment (the normal consequence of CON retries) isn't appended
+// twice.
+type blockwiseRecv struct {
+	buf  []byte
+	next uint32
+}
+
+// blockwiseDone caches the response a completed Block1 transfer was
+// answered with, so a retransmitted final fragment -- sent because the
+// original response was lost in flight -- gets that same response
+// replayed instead of silently falling on the floor once the reassembly
+// state for its key is gone.
+type blockwiseDone struct {
+	resp *Message
+}
+
+// A BlockwiseTransport wraps a RequestHandler to add RFC 7959 support
+// for resources whose representation exceeds maxPktLen. Outbound
+// responses larger than the negotiated block size are buffered per
+// (peer, token) and served one Block2 at a time as the client re-GETs
+// with an incremented block number; inbound Block1 fragments are
+// reassembled into a single Message.Payload before the wrapped handler
+// ever sees them.
+type BlockwiseTransport struct {
+	rh  RequestHandler
+	SZX uint8 // default block size exponent offered to clients
+
+	mu    sync.Mutex
+	sends map[blockwiseKey][]byte         // buffered outbound bodies awaiting further Block2 GETs
+	recvs map[blockwiseKey]*blockwiseRecv // partial inbound bodies awaiting the final Block1 fragment
+	done  map[blockwiseKey]*blockwiseDone // completed Block1 transfers, for replay on a retransmitted final fragment
+}
+
+// NewBlockwiseTransport wraps rh with Block1/Block2 support, offering
+// clients blocks of 2^(szx+4) bytes. An szx of 0 is treated as unset
+// and selects defaultBlockSZX (1024-byte blocks), not 16-byte blocks.
+func NewBlockwiseTransport(rh RequestHandler, szx uint8) *BlockwiseTransport {
+	return &BlockwiseTransport{
+		rh:    rh,
+		SZX:   szx,
+		sends: make(map[blockwiseKey][]byte),
+		recvs: make(map[blockwiseKey]*blockwiseRecv),
+		done:  make(map[blockwiseKey]*blockwiseDone),
+	}
+}
+
+func tokenOf(m Message) []byte {
+	t, _ := m.Option(Token).([]byte)
+	return t
+}
+
+// HandleNotification implements Notifiable by forwarding to rh, so an
+// Observer underneath a BlockwiseTransport still sees a Reset and
+// deregisters its Subscription immediately instead of only via its
+// reaper.
+func (bt *BlockwiseTransport) HandleNotification(a *net.UDPAddr, m Message) {
+	if o, ok := bt.rh.(Notifiable); ok {
+		o.HandleNotification(a, m)
+	}
+}
+
+// Handle implements RequestHandler.
+func (bt *BlockwiseTransport) Handle(l *net.UDPConn, a *net.UDPAddr, m Message) *Message {
+	key := blockwiseKey{addr: a.String(), token: string(tokenOf(m))}
+
+	finishingBlock1 := false
+	if num, more, szx := m.Block1(); m.Option(Block1) != nil {
+		bt.mu.Lock()
+		r := bt.recvs[key]
+		if r == nil {
+			if num == 0 {
+				// A fresh transfer reusing this peer+token:
+				// forget any earlier completion cached under it.
+				delete(bt.done, key)
+			} else if d, ok := bt.done[key]; ok {
+				// The final fragment of a transfer we already
+				// completed, sent again because our response to
+				// it was lost: replay that response rather than
+				// silently dropping the retransmission.
+				bt.mu.Unlock()
+				return d.resp
+			}
+			r = &blockwiseRecv{}
+			bt.recvs[key] = r
+		}
+
+		// A block we've already reassembled, most likely a CON
+		// retransmission: re-acknowledge (or drop, if it was the
+		// final block) without appending it again.
+		dup := num != r.next
+		if !dup {
+			r.buf = append(r.buf, m.Payload...)
+			r.next++
+		}
+		buf := r.buf
+		if !more {
+			delete(bt.recvs, key)
+			finishingBlock1 = !dup
+		}
+		bt.mu.Unlock()
+
+		if more {
+			rv := &Message{Code: Continue, MessageID: m.MessageID}
+			rv.SetOption(Block1, encodeBlockOption(num, true, szx))
+			return rv
+		}
+		if dup {
+			return nil
+		}
+		m.Payload = buf
+		m.RemoveOption(Block1)
+	}
+
+	if num, _, szx := m.Block2(); m.Option(Block2) != nil && num > 0 {
+		bt.mu.Lock()
+		body, ok := bt.sends[key]
+		bt.mu.Unlock()
+		if ok {
+			return bt.serveBlock(m, key, body, num, szx)
+		}
+	}
+
+	rv := bt.rh.Handle(l, a, m)
+	if finishingBlock1 {
+		bt.mu.Lock()
+		bt.done[key] = &blockwiseDone{resp: rv}
+		bt.mu.Unlock()
+	}
+	if rv == nil {
+		return nil
+	}
+
+	szx := bt.SZX
+	if szx == 0 {
+		szx = defaultBlockSZX
+	}
+	if len(rv.Payload) <= blockSize(szx) {
+		return rv
+	}
+
+	bt.mu.Lock()
+	bt.sends[key] = rv.Payload
+	bt.mu.Unlock()
+	return bt.serveBlock(m, key, rv.Payload, 0, szx)
+}
+
+// serveBlock slices out block num of body at the given SZX, replying
+// with Block2 set and forgetting the buffered body once the final
+// block has been served.
+func (bt *BlockwiseTransport) serveBlock(m Message, key blockwiseKey, body []byte, num uint32, szx uint8) *Message {
+	size := blockSize(szx)
+	start := int(num) * size
+	if start > len(body) {
+		start = len(body)
+	}
+	end := start + size
+	more := true
+	if end >= len(body) {
+		end = len(body)
+		more = false
+		bt.mu.Lock()
+		delete(bt.sends, key)
+		bt.mu.Unlock()
+	}
+
+	rv := &Message{Code: Content, MessageID: m.MessageID, Payload: body[start:end]}
+	rv.SetOption(Block2, encodeBlockOption(num, more, szx))
+	return rv
+}
@@ -0,0 +1,140 @@
+package coap
+
+import (
+	"net"
+	"testing"
+)
+
+// TestBlockwiseBlock1RetransmittedFinalFragment reproduces a client CON
+// retransmission of the last Block1 fragment after the server's own
+// response to it was lost: the server must replay the response it
+// already computed rather than dropping the retransmission on the
+// floor, since by the time it arrives the reassembly state for that
+// transfer is gone.
+func TestBlockwiseBlock1RetransmittedFinalFragment(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5683}
+	token := []byte{0x01}
+
+	var received []byte
+	bt := NewBlockwiseTransport(FuncHandler(func(l *net.UDPConn, a *net.UDPAddr, m Message) *Message {
+		received = append([]byte(nil), m.Payload...)
+		return &Message{Code: Content, MessageID: m.MessageID, Payload: []byte("stored")}
+	}), defaultBlockSZX)
+
+	block1 := func(num uint32, more bool, payload []byte) Message {
+		m := Message{Type: Confirmable, Code: PUT, MessageID: uint16(num + 1), Payload: payload}
+		m.SetOption(Token, token)
+		m.SetOption(Block1, encodeBlockOption(num, more, 0))
+		return m
+	}
+
+	if rv := bt.Handle(nil, addr, block1(0, true, []byte("hel"))); rv == nil || rv.Code != Continue {
+		t.Fatalf("block 0: got %+v, want a Continue ACK", rv)
+	}
+
+	final := block1(1, false, []byte("lo"))
+	first := bt.Handle(nil, addr, final)
+	if first == nil || first.Code != Content || string(first.Payload) != "stored" {
+		t.Fatalf("final block: got %+v, want the handler's response", first)
+	}
+	if string(received) != "hello" {
+		t.Fatalf("reassembled payload = %q, want %q", received, "hello")
+	}
+
+	// The client never saw `first` (it was lost in flight) and
+	// retransmits the same final CON.
+	retransmit := bt.Handle(nil, addr, final)
+	if retransmit == nil {
+		t.Fatal("retransmitted final Block1 fragment got no response; client's upload will time out")
+	}
+	if retransmit.Code != Content || string(retransmit.Payload) != "stored" {
+		t.Fatalf("retransmitted final block: got %+v, want the cached response replayed", retransmit)
+	}
+}
+
+// TestMessageLayerBlockwiseRetransmittedFinalFragment drives the same
+// scenario through server.go's documented composition,
+// NewMessageLayer(NewBlockwiseTransport(rh, szx)), rather than calling
+// BlockwiseTransport.Handle directly: a retransmitted final Block1
+// fragment keeps the CON's original MessageID, so MessageLayer's own
+// dedup (see exchange.go) sees it first and must be the one that
+// resends the cached response -- BlockwiseTransport.Handle is never
+// even reached a second time.
+func TestMessageLayerBlockwiseRetransmittedFinalFragment(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5683}
+	token := []byte{0x01}
+
+	var calls int
+	bt := NewBlockwiseTransport(FuncHandler(func(l *net.UDPConn, a *net.UDPAddr, m Message) *Message {
+		calls++
+		return &Message{Code: Content, Payload: []byte("stored")}
+	}), defaultBlockSZX)
+	ml := NewMessageLayer(bt)
+
+	block1 := func(num uint32, more bool, payload []byte) Message {
+		m := Message{Type: Confirmable, Code: PUT, MessageID: uint16(num + 1), Payload: payload}
+		m.SetOption(Token, token)
+		m.SetOption(Block1, encodeBlockOption(num, more, 0))
+		return m
+	}
+
+	if rv := ml.Handle(nil, addr, block1(0, true, []byte("hel"))); rv == nil || rv.Code != Continue {
+		t.Fatalf("block 0: got %+v, want a Continue ACK", rv)
+	}
+
+	final := block1(1, false, []byte("lo"))
+	first := ml.Handle(nil, addr, final)
+	if first == nil || first.Type != Acknowledgement || first.Code != Content || string(first.Payload) != "stored" {
+		t.Fatalf("final block: got %+v, want a piggybacked Content ACK", first)
+	}
+
+	retransmit := ml.Handle(nil, addr, final)
+	if retransmit == nil || retransmit.Type != Acknowledgement || retransmit.Code != Content || string(retransmit.Payload) != "stored" {
+		t.Fatalf("retransmitted final block through the full stack: got %+v, want the cached response replayed", retransmit)
+	}
+	if calls != 1 {
+		t.Fatalf("handler called %d times, want 1 (the retransmission must not reach it again)", calls)
+	}
+}
+
+// TestBlockwiseTransportForwardsHandleNotification verifies that
+// BlockwiseTransport implements Notifiable by forwarding to its wrapped
+// handler, so an Observer underneath it still deregisters a
+// Subscription immediately on Reset instead of only via its reaper.
+func TestBlockwiseTransportForwardsHandleNotification(t *testing.T) {
+	o := NewObserver()
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5683}
+	token := []byte("tok")
+
+	bt := NewBlockwiseTransport(observableHandler{
+		Observer: o,
+		RequestHandler: FuncHandler(func(l *net.UDPConn, a *net.UDPAddr, m Message) *Message {
+			return &Message{Code: Content}
+		}),
+	}, defaultBlockSZX)
+
+	o.Subscribe(nil, addr, token)
+	key := newSubscriptionKey(addr, token)
+	o.mu.Lock()
+	_, ok := o.subs[key]
+	o.mu.Unlock()
+	if !ok {
+		t.Fatal("Subscribe did not register the subscription")
+	}
+
+	n, ok := RequestHandler(bt).(Notifiable)
+	if !ok {
+		t.Fatal("*BlockwiseTransport does not implement Notifiable")
+	}
+
+	reset := Message{Type: Reset, MessageID: 1}
+	reset.SetOption(Token, token)
+	n.HandleNotification(addr, reset)
+
+	o.mu.Lock()
+	_, stillThere := o.subs[key]
+	o.mu.Unlock()
+	if stillThere {
+		t.Fatal("subscription survived a Reset forwarded through BlockwiseTransport")
+	}
+}
@@ -0,0 +1,157 @@
+package coap
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"math/rand"
+	"net"
+	"sync"
+)
+
+// A Client is a CoAP endpoint for talking to a single peer. It owns a
+// goroutine that reads the socket and dispatches replies to whichever
+// Do call is waiting on the matching Token, so callers never handle
+// MessageID allocation, token matching, or retransmission themselves.
+type Client struct {
+	conn *net.UDPConn
+	addr *net.UDPAddr
+
+	mu      sync.Mutex
+	waiting map[string]chan Message
+}
+
+// Dial opens a UDP socket to addr for use as a CoAP client.
+func Dial(network, addr string) (*Client, error) {
+	uaddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP(network, nil, uaddr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn:    conn,
+		addr:    uaddr,
+		waiting: make(map[string]chan Message),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// Close releases the underlying socket.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) readLoop() {
+	buf := make([]byte, maxPktLen)
+	for {
+		nr, err := c.conn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		m, err := parseMessage(buf[:nr])
+		if err != nil {
+			continue
+		}
+
+		if m.Type == Acknowledgement || m.Type == Reset {
+			defaultExchanges.ack(c.addr, m.MessageID, m.Type == Reset)
+		}
+		if m.Code == 0 {
+			// Empty ACK: the real response follows as a separate message.
+			continue
+		}
+
+		c.mu.Lock()
+		ch := c.waiting[string(tokenOf(m))]
+		c.mu.Unlock()
+		if ch != nil {
+			select {
+			case ch <- m:
+			default:
+			}
+		}
+
+		if m.Type == Confirmable {
+			Transmit(c.conn, c.addr, Message{Type: Acknowledgement, MessageID: m.MessageID})
+		}
+	}
+}
+
+// newToken generates a random 4-8 byte opaque Token value, per RFC
+// 7252 section 5.3.1.
+func newToken() []byte {
+	b := make([]byte, 4+rand.Intn(5))
+	cryptorand.Read(b)
+	return b
+}
+
+// Do sends req and returns the matching response, correlated by Token.
+// If req carries no Token, one is generated. Do handles CON
+// retransmission, and returns the reply whether it was piggybacked on
+// the ACK or delivered as a separate response.
+func (c *Client) Do(ctx context.Context, req Message) (Message, error) {
+	token, _ := req.Option(Token).([]byte)
+	if len(token) == 0 {
+		token = newToken()
+		req.SetOption(Token, token)
+	}
+	if req.MessageID == 0 {
+		req.MessageID = nextMessageID()
+	}
+
+	key := string(token)
+	ch := make(chan Message, 1)
+	c.mu.Lock()
+	c.waiting[key] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.waiting, key)
+		c.mu.Unlock()
+	}()
+
+	if err := TransmitConfirmable(ctx, c.conn, c.addr, req); err != nil {
+		return Message{}, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+func (c *Client) request(ctx context.Context, code uint8, path string, payload []byte) (Message, error) {
+	var m Message
+	m.Code = code
+	m.SetPathString(path)
+	m.Payload = payload
+	return c.Do(ctx, m)
+}
+
+// Get issues a GET request for path.
+func (c *Client) Get(ctx context.Context, path string) (Message, error) {
+	return c.request(ctx, GET, path, nil)
+}
+
+// Post issues a POST request for path with the given payload.
+func (c *Client) Post(ctx context.Context, path string, payload []byte) (Message, error) {
+	return c.request(ctx, POST, path, payload)
+}
+
+// Put issues a PUT request for path with the given payload.
+func (c *Client) Put(ctx context.Context, path string, payload []byte) (Message, error) {
+	return c.request(ctx, PUT, path, payload)
+}
+
+// Delete issues a DELETE request for path.
+func (c *Client) Delete(ctx context.Context, path string) (Message, error) {
+	return c.request(ctx, DELETE, path, nil)
+}
@@ -0,0 +1,124 @@
+package coap
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// startEchoServer answers every request with a piggybacked 2.05
+// Content response carrying the given payload and the request's Token.
+func startEchoServer(t *testing.T, payload []byte) (*net.UDPConn, func()) {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+
+	go func() {
+		buf := make([]byte, maxPktLen)
+		for {
+			nr, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			m, err := parseMessage(buf[:nr])
+			if err != nil {
+				continue
+			}
+
+			rv := Message{
+				Type:      Acknowledgement,
+				Code:      Content,
+				MessageID: m.MessageID,
+				Payload:   payload,
+			}
+			if token, ok := m.Option(Token).([]byte); ok {
+				rv.SetOption(Token, token)
+			}
+			Transmit(conn, addr, rv)
+		}
+	}()
+
+	return conn, func() { conn.Close() }
+}
+
+func TestClientGet(t *testing.T) {
+	srv, stop := startEchoServer(t, []byte("hello"))
+	defer stop()
+
+	c, err := Dial("udp", srv.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	resp, err := c.Get(ctx, "/sensors/temp")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if string(resp.Payload) != "hello" {
+		t.Errorf("Payload = %q, want %q", resp.Payload, "hello")
+	}
+}
+
+func TestClientDoRejectedWithReset(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer conn.Close()
+
+	go func() {
+		buf := make([]byte, maxPktLen)
+		nr, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		m, err := parseMessage(buf[:nr])
+		if err != nil {
+			return
+		}
+		Transmit(conn, addr, Message{Type: Reset, MessageID: m.MessageID})
+	}()
+
+	c, err := Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if _, err := c.Get(ctx, "/nope"); err != ErrReset {
+		t.Fatalf("Get returned err = %v, want ErrReset", err)
+	}
+}
+
+func TestClientDoTimeout(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close() // nothing answers requests sent to addr from here on
+
+	c, err := Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.Get(ctx, "/nope"); err == nil {
+		t.Fatal("Get returned nil error for a request nothing ever answered")
+	}
+}
@@ -0,0 +1,278 @@
+package coap
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is returned by TransmitConfirmable when a Confirmable
+// message goes unacknowledged after MAX_RETRANSMIT retries.
+var ErrTimeout = errors.New("coap: confirmable message timed out")
+
+// ErrReset is returned by TransmitConfirmable when the peer rejects a
+// Confirmable message with a Reset instead of acknowledging it.
+var ErrReset = errors.New("coap: confirmable message was reset by the peer")
+
+type peerMessageKey struct {
+	addr string
+	mid  uint16
+}
+
+// peerTokenKey identifies a request by the peer that sent it and the
+// Token it carried, so that two different clients choosing the same
+// Token for a deferred request can't clobber each other's entry.
+type peerTokenKey struct {
+	addr  string
+	token string
+}
+
+// exchangeTable holds the state shared between TransmitConfirmable's
+// retransmission loop, a MessageLayer's deduplication of inbound
+// Confirmable requests, and deferred separate responses handed out via
+// MessageLayer.Respond.
+type exchangeTable struct {
+	mu   sync.Mutex
+	acks map[peerMessageKey]chan error
+
+	dmu       sync.Mutex
+	dedup     map[peerMessageKey]time.Time
+	responses map[peerMessageKey]*Message
+
+	rmu      sync.Mutex
+	deferred map[peerTokenKey]*net.UDPAddr
+}
+
+func newExchangeTable() *exchangeTable {
+	t := &exchangeTable{
+		acks:      make(map[peerMessageKey]chan error),
+		dedup:     make(map[peerMessageKey]time.Time),
+		responses: make(map[peerMessageKey]*Message),
+		deferred:  make(map[peerTokenKey]*net.UDPAddr),
+	}
+	go t.reapDedup()
+	return t
+}
+
+func (t *exchangeTable) reapDedup() {
+	for range time.Tick(EXCHANGE_LIFETIME) {
+		cut := time.Now().Add(-EXCHANGE_LIFETIME)
+		t.dmu.Lock()
+		for k, seen := range t.dedup {
+			if seen.Before(cut) {
+				delete(t.dedup, k)
+				delete(t.responses, k)
+			}
+		}
+		t.dmu.Unlock()
+	}
+}
+
+// seen records a Confirmable request and reports whether it is a
+// retransmission of one already recorded within EXCHANGE_LIFETIME.
+func (t *exchangeTable) seen(a *net.UDPAddr, mid uint16) bool {
+	k := peerMessageKey{a.String(), mid}
+	t.dmu.Lock()
+	defer t.dmu.Unlock()
+	_, dup := t.dedup[k]
+	t.dedup[k] = time.Now()
+	return dup
+}
+
+// rememberResponse caches the response a Confirmable request was
+// answered with, keyed the same way as seen, so a retransmission of
+// that request can be re-acknowledged with the same response (see
+// lastResponse) instead of silently dropped: RFC 7252 section 4.5
+// requires a duplicate CON still be ACKed, to stop the sender's
+// retransmit timer, even though the handler isn't run again.
+func (t *exchangeTable) rememberResponse(a *net.UDPAddr, mid uint16, resp *Message) {
+	t.dmu.Lock()
+	t.responses[peerMessageKey{a.String(), mid}] = resp
+	t.dmu.Unlock()
+}
+
+// lastResponse returns the response previously cached by
+// rememberResponse for (a, mid), if any.
+func (t *exchangeTable) lastResponse(a *net.UDPAddr, mid uint16) (*Message, bool) {
+	t.dmu.Lock()
+	defer t.dmu.Unlock()
+	resp, ok := t.responses[peerMessageKey{a.String(), mid}]
+	return resp, ok
+}
+
+func (t *exchangeTable) await(a *net.UDPAddr, mid uint16) chan error {
+	ch := make(chan error, 1)
+	t.mu.Lock()
+	t.acks[peerMessageKey{a.String(), mid}] = ch
+	t.mu.Unlock()
+	return ch
+}
+
+func (t *exchangeTable) forget(a *net.UDPAddr, mid uint16) {
+	t.mu.Lock()
+	delete(t.acks, peerMessageKey{a.String(), mid})
+	t.mu.Unlock()
+}
+
+// ack completes the exchange for (a, mid), if one is outstanding, with
+// nil on a genuine Acknowledgement or ErrReset if the peer sent a
+// Reset instead.
+func (t *exchangeTable) ack(a *net.UDPAddr, mid uint16, reset bool) {
+	k := peerMessageKey{a.String(), mid}
+	t.mu.Lock()
+	ch := t.acks[k]
+	delete(t.acks, k)
+	t.mu.Unlock()
+	if ch == nil {
+		return
+	}
+	if reset {
+		ch <- ErrReset
+	} else {
+		ch <- nil
+	}
+}
+
+var defaultExchanges = newExchangeTable()
+
+// TransmitConfirmable sends m as a Confirmable message and retransmits
+// it with exponential backoff (ACK_TIMEOUT, doubling on each of up to
+// MAX_RETRANSMIT retries, jittered by ACK_RANDOM_FACTOR) until it is
+// acknowledged or ctx is done. It returns ErrTimeout if no ACK arrives
+// before the retries are exhausted, or ErrReset if the peer rejects the
+// message with a Reset. A MessageLayer handling l's incoming packets (or,
+// for a Client's socket, the Client's own read loop) is what delivers
+// the ACK or Reset that satisfies this call.
+func TransmitConfirmable(ctx context.Context, l *net.UDPConn, a *net.UDPAddr, m Message) error {
+	m.Type = Confirmable
+	if m.MessageID == 0 {
+		m.MessageID = nextMessageID()
+	}
+
+	ch := defaultExchanges.await(a, m.MessageID)
+	defer defaultExchanges.forget(a, m.MessageID)
+
+	timeout := ACK_TIMEOUT
+	for attempt := 0; ; attempt++ {
+		if err := Transmit(l, a, m); err != nil {
+			return err
+		}
+
+		wait := time.Duration(float64(timeout) * (1 + rand.Float64()*(ACK_RANDOM_FACTOR-1)))
+		select {
+		case err := <-ch:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		if attempt >= MAX_RETRANSMIT {
+			return ErrTimeout
+		}
+		timeout *= 2
+	}
+}
+
+// A MessageLayer wraps a RequestHandler with the reliability semantics
+// of RFC 7252 section 4: it deduplicates repeated Confirmable requests
+// instead of redelivering them, acknowledges Confirmable requests
+// automatically (piggybacking the handler's response, or an empty ACK
+// if the handler defers), and routes incoming Acknowledgement/Reset
+// messages back to outstanding TransmitConfirmable calls and Observer
+// subscriptions.
+//
+// Wrap a handler once with NewMessageLayer and pass the result to
+// ListenAndServe in place of the original handler.
+type MessageLayer struct {
+	rh RequestHandler
+	t  *exchangeTable
+}
+
+// NewMessageLayer wraps rh to add CoAP's Confirmable/Acknowledgement
+// reliability semantics.
+func NewMessageLayer(rh RequestHandler) *MessageLayer {
+	return &MessageLayer{rh: rh, t: defaultExchanges}
+}
+
+// Notifiable is implemented by a RequestHandler wrapper that defers to
+// an inner RequestHandler and needs inbound Acknowledgement/Reset
+// messages forwarded to it, even though MessageLayer intercepts those
+// messages itself rather than passing them through Handle. BlockwiseTransport
+// and ServeMux implement it by forwarding to whatever they wrap, so
+// that an Observer underneath either one still sees a Reset and
+// deregisters the matching Subscription immediately instead of waiting
+// on its reaper.
+type Notifiable interface {
+	HandleNotification(a *net.UDPAddr, m Message)
+}
+
+// Handle implements RequestHandler.
+func (ml *MessageLayer) Handle(l *net.UDPConn, a *net.UDPAddr, m Message) *Message {
+	if m.Type == Acknowledgement || m.Type == Reset {
+		ml.t.ack(a, m.MessageID, m.Type == Reset)
+		if o, ok := ml.rh.(Notifiable); ok {
+			o.HandleNotification(a, m)
+		}
+		return nil
+	}
+
+	if m.Type == Confirmable && ml.t.seen(a, m.MessageID) {
+		// A retransmission of a request we've already answered: resend
+		// the same response (an empty ACK if the original is still
+		// being processed) rather than dropping it, so the sender's
+		// retransmit timer actually stops per RFC 7252 section 4.5.
+		if resp, ok := ml.t.lastResponse(a, m.MessageID); ok {
+			return resp
+		}
+		return &Message{Type: Acknowledgement, MessageID: m.MessageID}
+	}
+
+	rv := ml.rh.Handle(l, a, m)
+
+	if m.Type != Confirmable {
+		return rv
+	}
+
+	var resp *Message
+	if rv == nil {
+		if token, ok := m.Option(Token).([]byte); ok && len(token) > 0 {
+			ml.t.rmu.Lock()
+			ml.t.deferred[peerTokenKey{a.String(), string(token)}] = a
+			ml.t.rmu.Unlock()
+		}
+		resp = &Message{Type: Acknowledgement, MessageID: m.MessageID}
+	} else {
+		if rv.Type != Reset {
+			rv.Type = Acknowledgement
+		}
+		rv.MessageID = m.MessageID
+		resp = rv
+	}
+
+	ml.t.rememberResponse(a, m.MessageID, resp)
+	return resp
+}
+
+// Respond sends m as the separate response to the request from a,
+// identified by token, that was previously deferred because the
+// handler's Handle returned nil. It is delivered reliably via
+// TransmitConfirmable.
+func (ml *MessageLayer) Respond(ctx context.Context, l *net.UDPConn, a *net.UDPAddr, token []byte, m Message) error {
+	key := peerTokenKey{a.String(), string(token)}
+
+	ml.t.rmu.Lock()
+	_, ok := ml.t.deferred[key]
+	delete(ml.t.deferred, key)
+	ml.t.rmu.Unlock()
+
+	if !ok {
+		return errors.New("coap: no deferred request for token")
+	}
+
+	m.SetOption(Token, token)
+	return TransmitConfirmable(ctx, l, a, m)
+}
@@ -0,0 +1,126 @@
+package coap
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestMessageLayerDedupDeliversRequestOnce verifies that a retransmitted
+// Confirmable request (matched by peer + MessageID) is never redelivered
+// to the wrapped handler a second time, but still gets the same response
+// resent -- per RFC 7252 section 4.5, a duplicate CON must be ACKed, not
+// silently dropped, or the sender keeps retransmitting until it times out.
+func TestMessageLayerDedupDeliversRequestOnce(t *testing.T) {
+	var calls int
+	ml := NewMessageLayer(FuncHandler(func(l *net.UDPConn, a *net.UDPAddr, m Message) *Message {
+		calls++
+		return &Message{Code: Content, Payload: []byte("x")}
+	}))
+
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5683}
+	req := Message{Type: Confirmable, MessageID: 42, Code: GET}
+
+	rv1 := ml.Handle(nil, addr, req)
+	if rv1 == nil || rv1.Type != Acknowledgement || rv1.Code != Content {
+		t.Fatalf("first request: got %+v, want a piggybacked Content ACK", rv1)
+	}
+
+	rv2 := ml.Handle(nil, addr, req)
+	if rv2 == nil || rv2.Type != Acknowledgement || rv2.Code != Content || string(rv2.Payload) != "x" {
+		t.Fatalf("retransmitted request: got %+v, want the same Content ACK resent", rv2)
+	}
+	if calls != 1 {
+		t.Fatalf("handler called %d times, want 1", calls)
+	}
+}
+
+// TestMessageLayerRespondDeliversDeferredResponse verifies the separate
+// response path: a handler that returns nil gets an empty ACK sent
+// immediately, and the response it later hands to Respond is delivered
+// reliably and correlated back to the original request by Token.
+func TestMessageLayerRespondDeliversDeferredResponse(t *testing.T) {
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer client.Close()
+
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer server.Close()
+
+	ml := NewMessageLayer(FuncHandler(func(l *net.UDPConn, a *net.UDPAddr, m Message) *Message {
+		return nil // always defer
+	}))
+	go func() {
+		buf := make([]byte, maxPktLen)
+		for {
+			nr, addr, err := server.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			m, err := parseMessage(buf[:nr])
+			if err != nil {
+				continue
+			}
+			if rv := ml.Handle(server, addr, m); rv != nil {
+				Transmit(server, addr, *rv)
+			}
+		}
+	}()
+
+	token := []byte("tok")
+	req := Message{Type: Confirmable, MessageID: 7, Code: GET}
+	req.SetOption(Token, token)
+	if err := Transmit(client, server.LocalAddr().(*net.UDPAddr), req); err != nil {
+		t.Fatalf("Transmit request: %v", err)
+	}
+
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, maxPktLen)
+
+	nr, _, err := client.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("reading empty ACK: %v", err)
+	}
+	ack, err := parseMessage(buf[:nr])
+	if err != nil {
+		t.Fatalf("parsing empty ACK: %v", err)
+	}
+	if ack.Type != Acknowledgement || len(ack.Payload) != 0 {
+		t.Fatalf("got %+v, want an empty Acknowledgement", ack)
+	}
+
+	clientAddr := client.LocalAddr().(*net.UDPAddr)
+	done := make(chan error, 1)
+	go func() {
+		done <- ml.Respond(context.Background(), server, clientAddr, token,
+			Message{Code: Content, Payload: []byte("late")})
+	}()
+
+	nr, sepAddr, err := client.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("reading separate response: %v", err)
+	}
+	sep, err := parseMessage(buf[:nr])
+	if err != nil {
+		t.Fatalf("parsing separate response: %v", err)
+	}
+	if sep.Type != Confirmable || sep.Code != Content || string(sep.Payload) != "late" {
+		t.Fatalf("got %+v, want a Confirmable Content response of \"late\"", sep)
+	}
+	Transmit(client, sepAddr, Message{Type: Acknowledgement, MessageID: sep.MessageID})
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Respond: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Respond never returned")
+	}
+}
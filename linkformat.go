@@ -0,0 +1,57 @@
+package coap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NewContentType returns a pointer to m, for populating
+// ResourceAttrs.ContentType (a plain MediaType field couldn't tell
+// "unset" apart from the valid content-format MediaType(0)).
+func NewContentType(m MediaType) *MediaType {
+	return &m
+}
+
+// ResourceAttrs describes the CoRE Link Format (RFC 6690) attributes
+// to advertise for a resource registered on a ServeMux.
+type ResourceAttrs struct {
+	ResourceType string     // rt
+	Interface    string     // if
+	ContentType  *MediaType // ct; nil means omit the attribute (MediaType(0) is a valid content-format, text/plain)
+	Observable   bool       // obs
+}
+
+func (a *ResourceAttrs) encode() string {
+	if a == nil {
+		return ""
+	}
+
+	var attrs []string
+	if a.ResourceType != "" {
+		attrs = append(attrs, fmt.Sprintf(`rt=%q`, a.ResourceType))
+	}
+	if a.Interface != "" {
+		attrs = append(attrs, fmt.Sprintf(`if=%q`, a.Interface))
+	}
+	if a.ContentType != nil {
+		attrs = append(attrs, fmt.Sprintf("ct=%d", *a.ContentType))
+	}
+	if a.Observable {
+		attrs = append(attrs, "obs")
+	}
+	return strings.Join(attrs, ";")
+}
+
+// encodeLinkFormat renders entries as an RFC 6690 CoRE Link Format
+// document, e.g. `</sensors/temp>;rt="temperature";if="sensor"`.
+func encodeLinkFormat(entries []*muxEntry) []byte {
+	links := make([]string, 0, len(entries))
+	for _, e := range entries {
+		link := "<" + e.path + ">"
+		if attrs := e.attrs.encode(); attrs != "" {
+			link += ";" + attrs
+		}
+		links = append(links, link)
+	}
+	return []byte(strings.Join(links, ","))
+}
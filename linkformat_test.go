@@ -0,0 +1,18 @@
+package coap
+
+import "testing"
+
+// TestResourceAttrsEncodeContentTypeZero verifies that ct=0
+// (MediaType(0), text/plain) is rendered rather than treated as unset,
+// while a ResourceAttrs with no ContentType at all still omits ct.
+func TestResourceAttrsEncodeContentTypeZero(t *testing.T) {
+	withCT := &ResourceAttrs{ResourceType: "led", ContentType: NewContentType(TextPlain)}
+	if got, want := withCT.encode(), `rt="led";ct=0`; got != want {
+		t.Errorf("encode() = %q, want %q", got, want)
+	}
+
+	withoutCT := &ResourceAttrs{ResourceType: "led"}
+	if got, want := withoutCT.encode(), `rt="led"`; got != want {
+		t.Errorf("encode() = %q, want %q", got, want)
+	}
+}
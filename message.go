@@ -57,20 +57,24 @@ var OptionGapTooLarge = errors.New("Option gap too large")
 type OptionID uint8
 
 const (
-	ContentType   = OptionID(1)
-	MaxAge        = OptionID(2)
-	ProxyURI      = OptionID(3)
+	IfMatch       = OptionID(1)
+	URIHost       = OptionID(3)
 	ETag          = OptionID(4)
-	URIHost       = OptionID(5)
-	LocationPath  = OptionID(6)
+	IfNoneMatch   = OptionID(5)
+	Observe       = OptionID(6)
 	URIPort       = OptionID(7)
-	LocationQuery = OptionID(8)
-	URIPath       = OptionID(9)
-	Token         = OptionID(11)
-	Accept        = OptionID(12)
-	IfMatch       = OptionID(13)
+	LocationPath  = OptionID(8)
+	URIPath       = OptionID(11)
+	ContentType   = OptionID(12)
+	MaxAge        = OptionID(14)
 	UriQuery      = OptionID(15)
-	IfNoneMatch   = OptionID(21)
+	Accept        = OptionID(17)
+	Token         = OptionID(19)
+	LocationQuery = OptionID(20)
+	Block2        = OptionID(23)
+	Block1        = OptionID(27)
+	ProxyURI      = OptionID(35)
+	Size1         = OptionID(60)
 )
 
 type MediaType byte
@@ -85,23 +89,36 @@ const (
 )
 
 /*
+   Option numbers follow the final RFC 7252 registry (as extended by
+   RFC 7641 for Observe and RFC 7959 for Block1/Block2); this differs
+   from early CoAP drafts, so do not assume the numeric values match
+   historical dumps of this table. Token is listed alongside them for
+   reference only: it is a header-level field (TKL plus 0-8 raw bytes),
+   never an IANA option number, but this library encodes/decodes it
+   through the same Option accessors as a library-internal convenience,
+   so it gets a reserved OptionID here to keep that plumbing uniform.
+
    +-----+---+---+----------------+--------+---------+-------------+
    | No. | C | R | Name           | Format | Length  | Default     |
    +-----+---+---+----------------+--------+---------+-------------+
-   |   1 | x |   | Content-Type   | uint   | 0-2 B   | (none)      |
-   |   2 |   |   | Max-Age        | uint   | 0-4 B   | 60          |
-   |   3 | x | x | Proxy-Uri      | string | 1-270 B | (none)      |
+   |   1 | x | x | If-Match       | opaque | 0-8 B   | (none)      |
+   |   3 | x |   | Uri-Host       | string | 1-270 B | (see below) |
    |   4 |   | x | ETag           | opaque | 1-8 B   | (none)      |
-   |   5 | x |   | Uri-Host       | string | 1-270 B | (see below) |
-   |   6 |   | x | Location-Path  | string | 0-270 B | (none)      |
+   |   5 | x |   | If-None-Match  | empty  | 0 B     | (none)      |
+   |   6 |   | x | Observe        | uint   | 0-3 B   | (none)      |
    |   7 | x |   | Uri-Port       | uint   | 0-2 B   | (see below) |
-   |   8 |   | x | Location-Query | string | 0-270 B | (none)      |
-   |   9 | x | x | Uri-Path       | string | 0-270 B | (none)      |
-   |  11 | x |   | Token          | opaque | 1-8 B   | (empty)     |
-   |  12 |   | x | Accept         | uint   | 0-2 B   | (none)      |
-   |  13 | x | x | If-Match       | opaque | 0-8 B   | (none)      |
+   |   8 |   | x | Location-Path  | string | 0-270 B | (none)      |
+   |  11 | x | x | Uri-Path       | string | 0-270 B | (none)      |
+   |  12 |   |   | Content-Type   | uint   | 0-2 B   | (none)      |
+   |  14 |   |   | Max-Age        | uint   | 0-4 B   | 60          |
    |  15 | x | x | Uri-Query      | string | 0-270 B | (none)      |
-   |  21 | x |   | If-None-Match  | empty  | 0 B     | (none)      |
+   |  17 |   | x | Accept         | uint   | 0-2 B   | (none)      |
+   |  19 | x |   | Token          | n/a -- header field, not an option |
+   |  20 |   | x | Location-Query | string | 0-270 B | (none)      |
+   |  23 | x | x | Block2         | uint   | 0-3 B   | (none)      |
+   |  27 | x | x | Block1         | uint   | 0-3 B   | (none)      |
+   |  35 | x | x | Proxy-Uri      | string | 1-270 B | (none)      |
+   |  60 |   | x | Size1          | uint   | 0-4 B   | (none)      |
    +-----+---+---+----------------+--------+---------+-------------+
 */
 
@@ -217,6 +234,41 @@ func (m Message) Path() []string {
 	return rv
 }
 
+// Option returns the value of the first option with the given ID, or
+// nil if the message does not carry one.
+func (m Message) Option(o OptionID) interface{} {
+	for _, opt := range m.opts {
+		if opt.ID == o {
+			return opt.Value
+		}
+	}
+	return nil
+}
+
+// Block2 decodes this message's Block2 option, if any, into its block
+// number, whether more blocks follow, and the negotiated block size
+// exponent (block size is 2^(szx+4) bytes), per RFC 7959 section 2.2.
+func (m Message) Block2() (num uint32, more bool, szx uint8) {
+	return decodeBlockOption(m.Option(Block2))
+}
+
+// Block1 decodes this message's Block1 option the same way as Block2.
+func (m Message) Block1() (num uint32, more bool, szx uint8) {
+	return decodeBlockOption(m.Option(Block1))
+}
+
+// Query returns the Uri-Query option values set on this message, each
+// typically of the form "key=value".
+func (m Message) Query() []string {
+	rv := []string{}
+	for _, o := range m.opts {
+		if o.ID == UriQuery {
+			rv = append(rv, o.Value.(string))
+		}
+	}
+	return rv
+}
+
 // Get a path as a / separated string.
 func (m Message) PathString() string {
 	return strings.Join(m.Path(), "/")
@@ -291,6 +343,12 @@ func encodeMessage(m Message) ([]byte, error) {
 	   +---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
 	   |   Option Value ...
 	   +---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+
+	   A gap of 15 or more between the previous option's ID and this
+	   one's is encoded the same way, in the Option Delta nibble: it
+	   becomes 1111 and an extra byte carries (gap - 15). This is what
+	   lets, e.g., a lone Token (ID 19) or Size1 (ID 60) option be
+	   encoded with no smaller-numbered option ahead of it.
 	*/
 
 	sort.Sort(&m.opts)
@@ -298,19 +356,35 @@ func encodeMessage(m Message) ([]byte, error) {
 	prev := 0
 	for _, o := range m.opts {
 		b := o.toBytes()
-		if len(b) > 15 {
-			buf.Write([]byte{
-				byte(int(o.ID)-prev)<<4 | 15,
-				byte(len(b) - 15),
-			})
+		gap := int(o.ID) - prev
+		if gap < 0 {
+			return nil, OptionGapTooLarge
+		}
+
+		head := byte(0)
+		var ext []byte
+
+		if gap > 14 {
+			if gap-15 > 255 {
+				return nil, OptionGapTooLarge
+			}
+			head |= 15 << 4
+			ext = append(ext, byte(gap-15))
 		} else {
-			buf.Write([]byte{byte(int(o.ID)-prev)<<4 | byte(len(b))})
+			head |= byte(gap) << 4
 		}
-		if int(o.ID)-prev > 15 {
-			return nil, OptionGapTooLarge
+
+		if len(b) > 14 {
+			head |= 15
+			ext = append(ext, byte(len(b)-15))
+		} else {
+			head |= byte(len(b))
 		}
 
+		buf.WriteByte(head)
+		buf.Write(ext)
 		buf.Write(b)
+
 		prev = int(o.ID)
 	}
 
@@ -320,7 +394,7 @@ func encodeMessage(m Message) ([]byte, error) {
 }
 
 func parseMessage(data []byte) (rv Message, err error) {
-	if len(data) < 6 {
+	if len(data) < 4 {
 		return rv, errors.New("Short packet")
 	}
 
@@ -340,13 +414,24 @@ func parseMessage(data []byte) (rv Message, err error) {
 	b := data[4:]
 	prev := 0
 	for i := 0; i < opCount && len(b) > 0; i++ {
-		oid := OptionID(prev + int(b[0]>>4))
+		gap := int(b[0] >> 4)
 		l := int(b[0] & 0xf)
 		b = b[1:]
+		if gap > 14 {
+			if len(b) == 0 {
+				return rv, errors.New("Truncated")
+			}
+			gap += int(b[0])
+			b = b[1:]
+		}
 		if l > 14 {
+			if len(b) == 0 {
+				return rv, errors.New("Truncated")
+			}
 			l += int(b[0])
 			b = b[1:]
 		}
+		oid := OptionID(prev + gap)
 		if len(b) < l {
 			return rv, errors.New("Truncated")
 		}
@@ -355,7 +440,11 @@ func parseMessage(data []byte) (rv Message, err error) {
 		case ContentType,
 			MaxAge,
 			URIPort,
-			Accept:
+			Accept,
+			Observe,
+			Block1,
+			Block2,
+			Size1:
 			opval = decodeInt(b[:l])
 		case ProxyURI, URIHost, LocationPath, LocationQuery, URIPath, UriQuery:
 			opval = string(b[:l])
@@ -0,0 +1,172 @@
+package coap
+
+import (
+	"net"
+	"strings"
+	"sync"
+)
+
+type muxEntry struct {
+	path    string
+	pattern []string // path segments; a trailing "*" matches the remainder
+	handler RequestHandler
+	attrs   *ResourceAttrs
+}
+
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+func matchesExact(segs, pattern []string) bool {
+	if len(segs) != len(pattern) {
+		return false
+	}
+	for i, p := range pattern {
+		if segs[i] != p {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesWildcard(segs, pattern []string) bool {
+	prefix := pattern[:len(pattern)-1]
+	if len(segs) < len(prefix) {
+		return false
+	}
+	for i, p := range prefix {
+		if segs[i] != p {
+			return false
+		}
+	}
+	return true
+}
+
+// A ServeMux routes requests by their Uri-Path, the way most CoAP
+// deployments otherwise end up reimplementing as a switch over
+// Message.PathString. It implements RequestHandler, so it can be
+// passed directly to ListenAndServe, and it auto-registers
+// /.well-known/core to serve an RFC 6690 CoRE Link Format listing of
+// every path registered on it.
+type ServeMux struct {
+	mu      sync.Mutex
+	entries []*muxEntry
+}
+
+// NewServeMux creates a ServeMux with /.well-known/core already
+// registered.
+func NewServeMux() *ServeMux {
+	mux := &ServeMux{}
+	mux.HandleResource("/.well-known/core", nil, FuncHandler(mux.serveDiscovery))
+	return mux
+}
+
+// HandleFunc registers f to handle requests under path.
+func (mux *ServeMux) HandleFunc(path string, f func(l *net.UDPConn, a *net.UDPAddr, m Message) *Message) {
+	mux.HandleResource(path, nil, FuncHandler(f))
+}
+
+// HandleResource registers h to handle requests under path, along with
+// the CoRE Link Format attributes to advertise for it from
+// /.well-known/core. path may end in "/*" to match any number of
+// trailing segments. attrs may be nil for a path with no attributes to
+// advertise (or that should not be advertised at all, such as
+// /.well-known/core itself).
+func (mux *ServeMux) HandleResource(path string, attrs *ResourceAttrs, h RequestHandler) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	mux.entries = append(mux.entries, &muxEntry{
+		path:    path,
+		pattern: splitPath(path),
+		handler: h,
+		attrs:   attrs,
+	})
+}
+
+// Handle implements RequestHandler, dispatching m to the handler whose
+// pattern best matches its Uri-Path: an exact match wins over a "/*"
+// wildcard, the longest matching "/*" wildcard wins over a shorter one
+// (registration order doesn't matter, so registering "/*" before
+// "/sensors/*" doesn't shadow the latter), and requests matching
+// nothing get a 4.04 Not Found.
+func (mux *ServeMux) Handle(l *net.UDPConn, a *net.UDPAddr, m Message) *Message {
+	segs := m.Path()
+
+	mux.mu.Lock()
+	entries := append([]*muxEntry(nil), mux.entries...)
+	mux.mu.Unlock()
+
+	var wildcard *muxEntry
+	for _, e := range entries {
+		if len(e.pattern) > 0 && e.pattern[len(e.pattern)-1] == "*" {
+			if matchesWildcard(segs, e.pattern) && (wildcard == nil || len(e.pattern) > len(wildcard.pattern)) {
+				wildcard = e
+			}
+			continue
+		}
+		if matchesExact(segs, e.pattern) {
+			return e.handler.Handle(l, a, m)
+		}
+	}
+	if wildcard != nil {
+		return wildcard.handler.Handle(l, a, m)
+	}
+
+	rspType := m.Type
+	if m.Type == Confirmable {
+		rspType = Acknowledgement
+	}
+	return &Message{Type: rspType, Code: NotFound, MessageID: m.MessageID}
+}
+
+// HandleNotification implements Notifiable by forwarding an inbound
+// Acknowledgement/Reset to every registered handler that implements it
+// in turn, since Uri-Path isn't available to route by on those message
+// types. A handler with no matching subscription for (a, m's Token) is
+// expected to no-op, so broadcasting is safe.
+func (mux *ServeMux) HandleNotification(a *net.UDPAddr, m Message) {
+	mux.mu.Lock()
+	entries := append([]*muxEntry(nil), mux.entries...)
+	mux.mu.Unlock()
+
+	for _, e := range entries {
+		if o, ok := e.handler.(Notifiable); ok {
+			o.HandleNotification(a, m)
+		}
+	}
+}
+
+// serveDiscovery answers /.well-known/core with a CoRE Link Format
+// listing of every registered resource, filtered by a "rt" Uri-Query
+// if one is present (e.g. ?rt=temperature).
+func (mux *ServeMux) serveDiscovery(l *net.UDPConn, a *net.UDPAddr, m Message) *Message {
+	var filterRT string
+	for _, q := range m.Query() {
+		if v := strings.TrimPrefix(q, "rt="); v != q {
+			filterRT = v
+		}
+	}
+
+	mux.mu.Lock()
+	entries := append([]*muxEntry(nil), mux.entries...)
+	mux.mu.Unlock()
+
+	listed := make([]*muxEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.path == "/.well-known/core" {
+			continue
+		}
+		if filterRT != "" && (e.attrs == nil || e.attrs.ResourceType != filterRT) {
+			continue
+		}
+		listed = append(listed, e)
+	}
+
+	rv := &Message{Type: Acknowledgement, Code: Content, MessageID: m.MessageID, Payload: encodeLinkFormat(listed)}
+	rv.SetOption(ContentType, AppLinkFormat)
+	return rv
+}
@@ -0,0 +1,150 @@
+package coap
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func newPathRequest(path string) Message {
+	m := Message{Type: Confirmable, Code: GET, MessageID: 1}
+	m.SetPath(splitPath(path))
+	return m
+}
+
+// TestServeMuxWildcardPrecedence verifies that the most specific "/*"
+// wildcard wins regardless of registration order: registering the
+// catch-all "/*" before the more specific "/sensors/*" must not shadow
+// the latter.
+func TestServeMuxWildcardPrecedence(t *testing.T) {
+	mux := NewServeMux()
+
+	var gotGeneric, gotSensors bool
+	mux.HandleFunc("/*", func(l *net.UDPConn, a *net.UDPAddr, m Message) *Message {
+		gotGeneric = true
+		return &Message{Code: Content}
+	})
+	mux.HandleFunc("/sensors/*", func(l *net.UDPConn, a *net.UDPAddr, m Message) *Message {
+		gotSensors = true
+		return &Message{Code: Content}
+	})
+
+	mux.Handle(nil, nil, newPathRequest("/sensors/temp"))
+
+	if gotGeneric {
+		t.Error("request matching /sensors/* was also routed to the catch-all /*")
+	}
+	if !gotSensors {
+		t.Error("request to /sensors/temp was not routed to /sensors/*")
+	}
+}
+
+// TestServeMuxDiscovery verifies that /.well-known/core lists registered
+// resources with their attributes, honors a ?rt= filter, and does not
+// drop a ct=0 attribute (MediaType(0), text/plain, is a valid
+// content-format, not "unset").
+func TestServeMuxDiscovery(t *testing.T) {
+	mux := NewServeMux()
+	mux.HandleResource("/sensors/temp", &ResourceAttrs{ResourceType: "temperature", Interface: "sensor"},
+		FuncHandler(func(l *net.UDPConn, a *net.UDPAddr, m Message) *Message { return nil }))
+	mux.HandleResource("/actuators/led", &ResourceAttrs{ContentType: NewContentType(TextPlain)},
+		FuncHandler(func(l *net.UDPConn, a *net.UDPAddr, m Message) *Message { return nil }))
+
+	rv := mux.Handle(nil, nil, newPathRequest("/.well-known/core"))
+	if rv == nil || rv.Code != Content {
+		t.Fatalf("discovery: got %+v, want 2.05 Content", rv)
+	}
+	body := string(rv.Payload)
+	if !strings.Contains(body, `</sensors/temp>;rt="temperature";if="sensor"`) {
+		t.Errorf("body %q missing the /sensors/temp link", body)
+	}
+	if !strings.Contains(body, "</actuators/led>;ct=0") {
+		t.Errorf("body %q dropped ct=0 for /actuators/led", body)
+	}
+	if strings.Contains(body, "well-known/core") {
+		t.Errorf("body %q should not list /.well-known/core itself", body)
+	}
+
+	filtered := newPathRequest("/.well-known/core")
+	filtered.AddOption(UriQuery, "rt=temperature")
+	rv = mux.Handle(nil, nil, filtered)
+	body = string(rv.Payload)
+	if !strings.Contains(body, "/sensors/temp") || strings.Contains(body, "/actuators/led") {
+		t.Errorf("?rt=temperature body = %q, want only /sensors/temp", body)
+	}
+}
+
+// TestServeMuxNotFoundPreservesRequestType verifies that a request to
+// an unregistered path gets a 4.04 whose Type matches the request: an
+// Acknowledgement for a Confirmable request, but the same (Non-
+// confirmable) Type for a NON request, since MessageLayer only forces
+// Type to Acknowledgement for a Confirmable request and otherwise
+// passes the response through unmodified.
+func TestServeMuxNotFoundPreservesRequestType(t *testing.T) {
+	mux := NewServeMux()
+
+	con := newPathRequest("/nope")
+	con.Type = Confirmable
+	if rv := mux.Handle(nil, nil, con); rv == nil || rv.Type != Acknowledgement || rv.Code != NotFound {
+		t.Fatalf("CON to unregistered path: got %+v, want an Acknowledgement 4.04", rv)
+	}
+
+	non := newPathRequest("/nope")
+	non.Type = NonConfirmable
+	if rv := mux.Handle(nil, nil, non); rv == nil || rv.Type != NonConfirmable || rv.Code != NotFound {
+		t.Fatalf("NON to unregistered path: got %+v, want a Non-confirmable 4.04", rv)
+	}
+}
+
+// observableHandler composes a RequestHandler with an *Observer the way
+// a resource that supports Observe would: HandleNotification is
+// promoted straight from the embedded *Observer, so registering one of
+// these on a ServeMux is all a handler needs to do to get Reset
+// deregistration forwarded to it.
+type observableHandler struct {
+	*Observer
+	RequestHandler
+}
+
+// TestServeMuxForwardsHandleNotification verifies that a ServeMux
+// implements Notifiable by broadcasting to every registered handler
+// that does, so an Observer reachable only through one of its routes
+// still deregisters a Subscription immediately on Reset.
+func TestServeMuxForwardsHandleNotification(t *testing.T) {
+	mux := NewServeMux()
+	o := NewObserver()
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5683}
+	token := []byte("tok")
+
+	mux.HandleResource("/sensors/temp", nil, observableHandler{
+		Observer: o,
+		RequestHandler: FuncHandler(func(l *net.UDPConn, a *net.UDPAddr, m Message) *Message {
+			return &Message{Code: Content}
+		}),
+	})
+
+	o.Subscribe(nil, addr, token)
+	key := newSubscriptionKey(addr, token)
+	o.mu.Lock()
+	_, ok := o.subs[key]
+	o.mu.Unlock()
+	if !ok {
+		t.Fatal("Subscribe did not register the subscription")
+	}
+
+	n, ok := RequestHandler(mux).(Notifiable)
+	if !ok {
+		t.Fatal("*ServeMux does not implement Notifiable")
+	}
+
+	reset := Message{Type: Reset, MessageID: 1}
+	reset.SetOption(Token, token)
+	n.HandleNotification(addr, reset)
+
+	o.mu.Lock()
+	_, stillThere := o.subs[key]
+	o.mu.Unlock()
+	if stillThere {
+		t.Fatal("subscription survived a Reset forwarded through ServeMux")
+	}
+}
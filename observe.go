@@ -0,0 +1,164 @@
+package coap
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var lastObserveMsgID uint32
+
+// nextMessageID returns a fresh MessageID for a message that is not a
+// reply to a request: a notification, a separate response, or a
+// Client request.
+func nextMessageID() uint16 {
+	return uint16(atomic.AddUint32(&lastObserveMsgID, 1))
+}
+
+type subscriptionKey struct {
+	addr  string
+	token string
+}
+
+func newSubscriptionKey(a *net.UDPAddr, token []byte) subscriptionKey {
+	return subscriptionKey{addr: a.String(), token: string(token)}
+}
+
+// A Subscription is a single client's registered interest in a resource,
+// as established by a GET request carrying Observe=0. A resource
+// handler holds on to the Subscription returned by Observer.Subscribe
+// and calls Notify whenever the resource's representation changes.
+type Subscription struct {
+	l     *net.UDPConn
+	addr  *net.UDPAddr
+	token []byte
+
+	mu       sync.Mutex
+	seq      uint32
+	lastSent time.Time
+	unacked  bool
+}
+
+// Notify pushes a new representation of the observed resource to the
+// client. It is sent Confirmable, via TransmitConfirmable, with a
+// monotonically increasing Observe option and the Token the client
+// registered with, per RFC 7641 section 3.4; a lost notification is
+// therefore retried the same as any other Confirmable message instead
+// of only being caught by the reaper on the next pass. The caller
+// supplies the response code, typically Content.
+func (s *Subscription) Notify(payload []byte, code uint8) error {
+	s.mu.Lock()
+	s.seq++
+	seq := s.seq
+
+	m := Message{
+		Code:    code,
+		Payload: payload,
+	}
+	m.SetOption(Observe, seq)
+	if len(s.token) > 0 {
+		m.SetOption(Token, s.token)
+	}
+
+	s.lastSent = time.Now()
+	s.unacked = true
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), MAX_TRANSMIT_WAIT)
+	defer cancel()
+	err := TransmitConfirmable(ctx, s.l, s.addr, m)
+
+	s.mu.Lock()
+	s.unacked = err != nil
+	s.mu.Unlock()
+
+	return err
+}
+
+func (s *Subscription) ack() {
+	s.mu.Lock()
+	s.unacked = false
+	s.mu.Unlock()
+}
+
+func (s *Subscription) expired() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unacked && time.Since(s.lastSent) > MAX_TRANSMIT_WAIT
+}
+
+// An Observer is the subscription registry for a resource (or set of
+// resources) that supports RFC 7641 Observe. A single Observer can be
+// shared by every handler that serves observable resources on a
+// server.
+type Observer struct {
+	mu   sync.Mutex
+	subs map[subscriptionKey]*Subscription
+}
+
+// NewObserver creates an empty subscription registry and starts its
+// reaper, which drops subscriptions whose most recent Confirmable
+// notification has gone unacknowledged past MAX_TRANSMIT_WAIT.
+func NewObserver() *Observer {
+	o := &Observer{subs: make(map[subscriptionKey]*Subscription)}
+	go o.reap()
+	return o
+}
+
+// Subscribe registers a client for notifications and returns the
+// Subscription a handler should retain to call Notify on. Call this
+// when a GET request arrives with an Observe option of 0.
+func (o *Observer) Subscribe(l *net.UDPConn, a *net.UDPAddr, token []byte) *Subscription {
+	s := &Subscription{l: l, addr: a, token: token}
+	o.mu.Lock()
+	o.subs[newSubscriptionKey(a, token)] = s
+	o.mu.Unlock()
+	return s
+}
+
+// Unsubscribe removes a client's subscription. Call this when a GET
+// request arrives with an Observe option of 1.
+func (o *Observer) Unsubscribe(a *net.UDPAddr, token []byte) {
+	o.mu.Lock()
+	delete(o.subs, newSubscriptionKey(a, token))
+	o.mu.Unlock()
+}
+
+// HandleNotification feeds an incoming message that is not itself a
+// fresh request back into the registry: a Reset deregisters the
+// matching subscription, and an Acknowledgement clears the unacked
+// flag set by Notify. Handlers that serve observable resources should
+// forward any message of Type Reset or Acknowledgement here before (or
+// instead of) treating it as a new request.
+func (o *Observer) HandleNotification(a *net.UDPAddr, m Message) {
+	token, _ := m.Option(Token).([]byte)
+	key := newSubscriptionKey(a, token)
+
+	switch m.Type {
+	case Reset:
+		o.mu.Lock()
+		delete(o.subs, key)
+		o.mu.Unlock()
+	case Acknowledgement:
+		o.mu.Lock()
+		s := o.subs[key]
+		o.mu.Unlock()
+		if s != nil {
+			s.ack()
+		}
+	}
+}
+
+func (o *Observer) reap() {
+	for range time.Tick(MAX_TRANSMIT_WAIT) {
+		o.mu.Lock()
+		for k, s := range o.subs {
+			if s.expired() {
+				delete(o.subs, k)
+			}
+		}
+		o.mu.Unlock()
+	}
+}
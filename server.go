@@ -9,7 +9,23 @@ import (
 
 const maxPktLen = 1500
 
-// Handle CoAP messages.
+// Transmission parameters from RFC 7252 section 4.8, used to size
+// retransmission timeouts and to decide when a peer has gone away.
+const (
+	ACK_TIMEOUT       = 2 * time.Second
+	ACK_RANDOM_FACTOR = 1.5
+	MAX_RETRANSMIT    = 4
+
+	MAX_TRANSMIT_SPAN = time.Duration(float64(ACK_TIMEOUT) * float64(uint(1)<<MAX_RETRANSMIT-1) * ACK_RANDOM_FACTOR)
+	MAX_TRANSMIT_WAIT = time.Duration(float64(ACK_TIMEOUT) * float64(uint(1)<<(MAX_RETRANSMIT+1)-1) * ACK_RANDOM_FACTOR)
+	EXCHANGE_LIFETIME = 247 * time.Second
+
+	RESPONSE_TIMEOUT = ACK_TIMEOUT
+)
+
+// Handle CoAP messages. Wrap a RequestHandler in a MessageLayer (see
+// exchange.go) to get CON retransmission, ACK, and deduplication for
+// free; ListenAndServe itself stays agnostic to Type.
 type RequestHandler interface {
 	// Handle the message and optionally return a response message.
 	Handle(l *net.UDPConn, a *net.UDPAddr, m Message) *Message
@@ -42,14 +58,16 @@ func handlePacket(l *net.UDPConn, data []byte, u *net.UDPAddr,
 	}
 }
 
-// Transmit a message.
+// Transmit a message. a is ignored (and may be nil) when l is already
+// connected to a single peer, e.g. a Client's socket, since WriteTo is
+// not valid on a pre-connected UDP connection.
 func Transmit(l *net.UDPConn, a *net.UDPAddr, m Message) error {
 	d, err := encodeMessage(m)
 	if err != nil {
 		return err
 	}
 
-	if a == nil {
+	if a == nil || l.RemoteAddr() != nil {
 		_, err = l.Write(d)
 	} else {
 		_, err = l.WriteTo(d, a)
@@ -69,7 +87,21 @@ func Receive(l *net.UDPConn) (Message, error) {
 	return parseMessage(data[:nr])
 }
 
-// Bind to the given address and serve requests forever.
+// Bind to the given address and serve requests forever. rh sees every
+// datagram as handlePacket decodes it, with no retransmission,
+// deduplication, or Block-wise reassembly applied on its behalf, so a
+// deployment that wants RFC 7252 reliability (CON retransmission, ACK,
+// dedup) and/or RFC 7959 Block-wise transfer needs to build that
+// composition itself and pass the result here in place of its own
+// handler. The layers nest outside-in in this order:
+//
+//	NewMessageLayer(NewBlockwiseTransport(mux, szx))
+//
+// MessageLayer goes outermost since it has to see raw, possibly
+// duplicated CONs to dedup and ACK them; BlockwiseTransport goes inside
+// it so the resource handler (a ServeMux, an Observer-backed handler,
+// or a bare RequestHandler) only ever sees a fully reassembled request
+// and its response gets sliced into Block2 automatically if needed.
 func ListenAndServe(n, addr string, rh RequestHandler) error {
 	uaddr, err := net.ResolveUDPAddr(n, addr)
 	if err != nil {